@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	tlsCAFile   = flag.String("tls-ca-file", "", "PEM file of CA certificates to trust for the hub API (defaults to the system pool)")
+	tlsCertFile = flag.String("tls-cert-file", "", "PEM file of the client certificate to present to the hub API")
+	tlsKeyFile  = flag.String("tls-key-file", "", "PEM file of the client private key matching --tls-cert-file")
+	tlsInsecure = flag.Bool("tls-insecure", false, "skip verification of the hub API's TLS certificate")
+	apiTimeout  = flag.Duration("api-timeout", 10*time.Second, "timeout for a single request to the hub API")
+	apiRetries  = flag.Int("api-max-retries", 3, "maximum number of retries for 5xx/429 responses from the hub API")
+	tokenFile   = flag.String("token-file", "", "file containing the jupyterhub admin token (falls back to the JUPYTERHUB_API_TOKEN env var)")
+)
+
+const apiTokenEnvVar = "JUPYTERHUB_API_TOKEN"
+
+var (
+	apiRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: namespace + "_api_requests_total",
+		Help: "Total number of requests made to the JupyterHub API, by method and status code.",
+	}, []string{"code", "method"})
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: namespace + "_api_request_duration_seconds",
+		Help: "Latency of requests made to the JupyterHub API.",
+	}, []string{"code", "method"})
+)
+
+// hubClient is a reusable client for the JupyterHub admin API, built once
+// at startup with the configured TLS, timeout, retry and auth settings.
+type hubClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// newHubClient resolves the admin token from --token-file or
+// JUPYTERHUB_API_TOKEN, builds the configured TLS transport, and wraps it
+// with promhttp instrumentation so outbound requests show up as
+// jupyterhub_api_requests_total / jupyterhub_api_request_duration_seconds.
+func newHubClient() (*hubClient, error) {
+	token, err := resolveToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: *tlsInsecure}
+
+	if *tlsCAFile != "" {
+		caCert, err := ioutil.ReadFile(*tlsCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading tls-ca-file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", *tlsCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if *tlsCertFile != "" || *tlsKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading tls client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// Clone rather than build from scratch so we keep http.DefaultTransport's
+	// Proxy/idle-conn tuning - notably HTTP_PROXY/HTTPS_PROXY support, which a
+	// bare &http.Transport{} silently drops.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	instrumented := promhttp.InstrumentRoundTripperDuration(apiRequestDuration,
+		promhttp.InstrumentRoundTripperCounter(apiRequestsTotal, transport))
+
+	return &hubClient{
+		baseURL: *apiHost,
+		token:   token,
+		http:    &http.Client{Transport: instrumented},
+	}, nil
+}
+
+func resolveToken() (string, error) {
+	if *tokenFile != "" {
+		data, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("reading token-file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if token := os.Getenv(apiTokenEnvVar); token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("no jupyterhub api token: set --token-file or %s", apiTokenEnvVar)
+}
+
+// do issues a single request with the configured per-request timeout,
+// retrying 5xx and 429 responses with exponential backoff - honoring a
+// Retry-After header when the hub sends one - up to apiRetries times.
+func (c *hubClient) do(method, url string) ([]byte, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= *apiRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(lastErr.(*retryableError).delay(attempt))
+		}
+
+		result, retry, err := c.attempt(method, url)
+		if err == nil {
+			return result, nil
+		}
+		if !retry {
+			return nil, err
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// retryableError wraps an error from an attempt that may be worth
+// retrying, carrying the Retry-After delay the server asked for (if any).
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+
+func (e *retryableError) delay(attempt int) time.Duration {
+	if e.retryAfter > 0 {
+		return e.retryAfter
+	}
+	return time.Duration(math.Pow(2, float64(attempt))) * 500 * time.Millisecond
+}
+
+// attempt performs a single HTTP round trip, reporting whether the error
+// (if any) is worth retrying.
+func (c *hubClient) attempt(method, url string) (result []byte, retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *apiTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Authorization", "token "+c.token)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, true, &retryableError{err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests {
+		return nil, true, &retryableError{
+			err:        fmt.Errorf("%s %s: %s", method, url, res.Status),
+			retryAfter: retryAfterDelay(res.Header.Get("Retry-After")),
+		}
+	}
+
+	if res.StatusCode >= 400 {
+		return nil, false, fmt.Errorf("%s %s: %s", method, url, res.Status)
+	}
+
+	result, err = ioutil.ReadAll(res.Body)
+	return result, false, err
+}
+
+func retryAfterDelay(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// GetUsers fetches the full /hub/api/users listing, paginating with
+// offset/limit since JupyterHub caps a single response at ~50 users. A
+// non-positive --page-size disables pagination (a single request with no
+// offset/limit) rather than looping forever on a zero-sized page.
+func (c *hubClient) GetUsers() (users ResponseJSON, err error) {
+	if *pageSize <= 0 {
+		resBody, apiErr := c.do("GET", c.baseURL+"/users")
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		err = json.Unmarshal(resBody, &users)
+		return users, err
+	}
+
+	offset := 0
+	for {
+		url := fmt.Sprintf("%s/users?offset=%d&limit=%d", c.baseURL, offset, *pageSize)
+		resBody, apiErr := c.do("GET", url)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		var page ResponseJSON
+		if err = json.Unmarshal(resBody, &page); err != nil {
+			return nil, err
+		}
+
+		users = append(users, page...)
+		if len(page) < *pageSize {
+			break
+		}
+		offset += *pageSize
+	}
+
+	return users, nil
+}
+
+// ServerProgressActive makes a best-effort check of a server's own
+// /server/progress endpoint for an activity signal beyond what
+// /hub/api/users reports. JupyterHub only serves this endpoint (with a
+// 200 and a non-empty body) while a server is actively spawning; any
+// other status - 404 once spawning is done, 5xx, a timeout - just means
+// "no additional signal", not an error worth surfacing. Callers should
+// treat this purely as a corroborating check, never the sole basis for
+// deciding a server is idle.
+func (c *hubClient) ServerProgressActive(username, serverName string) bool {
+	url := c.baseURL + "/users/" + username + "/server/progress"
+	if serverName != "" {
+		url = c.baseURL + "/users/" + username + "/servers/" + serverName + "/progress"
+	}
+
+	status, body, err := c.getRaw(url)
+	if err != nil || status != http.StatusOK {
+		return false
+	}
+	return len(body) > 0
+}
+
+// getRaw performs a single, non-retried GET, returning the raw status
+// code alongside the body so callers that need to branch on status (not
+// just success/failure) don't have to go through the retrying do/attempt
+// path.
+func (c *hubClient) getRaw(url string) (status int, body []byte, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *apiTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "token "+c.token)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err = ioutil.ReadAll(res.Body)
+	return res.StatusCode, body, err
+}
+
+// StopSingleServer stops a user's server - the default server if
+// serverName is empty, otherwise the named server.
+func (c *hubClient) StopSingleServer(username string, serverName string) error {
+	url := c.baseURL + "/users/" + username + "/server"
+	if serverName != "" {
+		url = c.baseURL + "/users/" + username + "/servers/" + serverName
+	}
+
+	if _, err := c.do("DELETE", url); err != nil {
+		return err
+	}
+	log.Println("stopped " + username + "'s server " + serverName)
+	return nil
+}
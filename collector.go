@@ -0,0 +1,169 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a pure Prometheus collector: it only reads the current
+// state of the hub and emits metrics from it. It must never mutate hub
+// state, since Prometheus can and does scrape the same target from
+// multiple replicas - any side effect belongs in Reaper instead.
+type Collector struct {
+	client *hubClient
+	policy *idlePolicy
+}
+
+func NewCollector(client *hubClient, policy *idlePolicy) *Collector {
+	return &Collector{client: client, policy: policy}
+}
+
+var (
+	usersTotalDesc = prometheus.NewDesc(
+		"users_total",
+		"Number of JupyterHub users by state.",
+		[]string{"state"}, nil,
+	)
+	serversRunningDesc = prometheus.NewDesc(
+		"servers_running",
+		"Number of currently running single-user servers.",
+		nil, nil,
+	)
+	userCreatedDesc = prometheus.NewDesc(
+		"user_created_seconds",
+		"Unix timestamp when the user account was created.",
+		[]string{"user"}, nil,
+	)
+	serverLastActivityDesc = prometheus.NewDesc(
+		"server_last_activity_seconds",
+		"Unix timestamp of the user's last recorded activity.",
+		[]string{"user"}, nil,
+	)
+	namedServerLastActivityDesc = prometheus.NewDesc(
+		"named_server_last_activity_seconds",
+		"Unix timestamp of the last recorded activity of a named server.",
+		[]string{"user", "server_name"}, nil,
+	)
+	serverStartedDesc = prometheus.NewDesc(
+		"server_started_seconds",
+		"Unix timestamp when the user's server was started.",
+		[]string{"user", "server_name"}, nil,
+	)
+	scrapeDurationDesc = prometheus.NewDesc(
+		"scrape_duration_seconds",
+		"Time spent collecting a scrape of the JupyterHub API.",
+		nil, nil,
+	)
+	scrapeErrorsTotalDesc = prometheus.NewDesc(
+		"scrape_errors_total",
+		"Total number of errors encountered while scraping the JupyterHub API.",
+		nil, nil,
+	)
+	userIdleThresholdDesc = prometheus.NewDesc(
+		"user_idle_threshold_seconds",
+		"Effective idle threshold, from the idle policy, after which the reaper will stop a user's servers.",
+		[]string{"user"}, nil,
+	)
+
+	// scrapeErrorsTotal is cumulative across scrapes, and promhttp may run
+	// concurrent scrapes (see --web.max-requests), so it's mutated via
+	// atomic rather than a bare counter.
+	scrapeErrorsTotal uint64
+)
+
+func (cc *Collector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(cc, ch)
+}
+
+func (cc *Collector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+
+	users, err := cc.client.GetUsers()
+	if err != nil {
+		log.Println(err)
+		total := atomic.AddUint64(&scrapeErrorsTotal, 1)
+		ch <- prometheus.MustNewConstMetric(scrapeErrorsTotalDesc, prometheus.CounterValue, float64(total))
+		ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
+		return
+	}
+
+	nowTimestamp := time.Now().UnixNano()
+	var activeCount, inactiveCount, adminCount, runningCount float64
+
+	for _, user := range users {
+		if user.Admin {
+			adminCount++
+		}
+
+		threshold := cc.policy.Threshold(user)
+		ch <- prometheus.MustNewConstMetric(userIdleThresholdDesc, prometheus.GaugeValue, threshold.Seconds(), user.Name)
+
+		if created := parseTimestamp(user.Created); !created.IsZero() {
+			ch <- prometheus.MustNewConstMetric(userCreatedDesc, prometheus.GaugeValue, float64(created.Unix()), user.Name)
+		}
+
+		lastActivity := parseTimestamp(user.LastActivity)
+		if !lastActivity.IsZero() {
+			ch <- prometheus.MustNewConstMetric(
+				serverLastActivityDesc,
+				prometheus.GaugeValue,
+				float64(lastActivity.Unix()),
+				user.Name,
+			)
+		}
+
+		// A server that's still spawning or stopping (pending != "") hasn't
+		// had a chance to report last_activity yet; count the user as active
+		// rather than letting a freshly-requested server read as idle.
+		pending := false
+		for _, server := range user.Servers {
+			if server.Pending != "" {
+				pending = true
+				break
+			}
+		}
+
+		if pending || time.Duration(nowTimestamp-lastActivity.UnixNano()) < threshold {
+			activeCount++
+		} else {
+			inactiveCount++
+		}
+
+		for serverName, server := range user.Servers {
+			if server.Ready {
+				runningCount++
+			}
+
+			if serverName != "" {
+				if serverActivity := parseTimestamp(server.LastActivity); !serverActivity.IsZero() {
+					ch <- prometheus.MustNewConstMetric(
+						namedServerLastActivityDesc,
+						prometheus.GaugeValue,
+						float64(serverActivity.Unix()),
+						user.Name, serverName,
+					)
+				}
+			}
+
+			if started := parseTimestamp(server.Started); !started.IsZero() {
+				ch <- prometheus.MustNewConstMetric(
+					serverStartedDesc,
+					prometheus.GaugeValue,
+					float64(started.Unix()),
+					user.Name, serverName,
+				)
+			}
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(usersTotalDesc, prometheus.GaugeValue, activeCount, "active")
+	ch <- prometheus.MustNewConstMetric(usersTotalDesc, prometheus.GaugeValue, inactiveCount, "inactive")
+	ch <- prometheus.MustNewConstMetric(usersTotalDesc, prometheus.GaugeValue, adminCount, "admin")
+	ch <- prometheus.MustNewConstMetric(serversRunningDesc, prometheus.GaugeValue, runningCount)
+
+	ch <- prometheus.MustNewConstMetric(scrapeErrorsTotalDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&scrapeErrorsTotal)))
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
+}
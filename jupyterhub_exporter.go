@@ -1,10 +1,7 @@
 package main
 
 import (
-	"crypto/tls"
-	"encoding/json"
 	"flag"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"time"
@@ -13,18 +10,37 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// ResponseJSON is struct of Jupyterhub response for /hub/api/users
-type ResponseJSON []struct {
+// ServerJSON is struct of a single named or default server, as nested
+// under a user's "servers" map in the /hub/api/users response.
+type ServerJSON struct {
 	Name         string `json:"name"`
-	Server       string `json:"server"`
 	LastActivity string `json:"last_activity"`
+	Started      string `json:"started"`
+	Pending      string `json:"pending"`
+	Ready        bool   `json:"ready"`
+}
+
+// UserJSON is struct of a single user, as returned by /hub/api/users. The
+// top-level "server"/"pending" fields JupyterHub reports for the default
+// server are redundant with user.Servers[""] and are intentionally not
+// captured here.
+type UserJSON struct {
+	Name         string                `json:"name"`
+	Admin        bool                  `json:"admin"`
+	Created      string                `json:"created"`
+	LastActivity string                `json:"last_activity"`
+	Groups       []string              `json:"groups"`
+	Servers      map[string]ServerJSON `json:"servers"`
 }
 
+// ResponseJSON is struct of Jupyterhub response for /hub/api/users
+type ResponseJSON []UserJSON
+
 var (
-	apiHost  = flag.String("host", "https://localhost/hub/api", "API host")
-	willStop = flag.Bool("stop", true, "stop single server")
-	apiToken = flag.String("token", "", "jupyterhub token (admin)")
-	waitHour = flag.Int64("hours", 24, "hours to wait for stop server")
+	apiHost           = flag.String("host", "https://localhost/hub/api", "API host")
+	waitHour          = flag.Int64("hours", 24, "hours to wait for stop server")
+	pageSize          = flag.Int("page-size", 50, "number of users to request per page from /hub/api/users")
+	maxConcurrentStop = flag.Int("max-concurrent-stops", 4, "maximum number of server stop requests to run concurrently")
 )
 
 const (
@@ -33,131 +49,68 @@ const (
 	dateLayout  = "2006-01-02T15:04:05.000000Z"
 )
 
-type myCollector struct{}
-
-var (
-	activeUserDesc = prometheus.NewDesc(
-		"active_user",
-		"Current active users.",
-		[]string{"userName"}, nil,
-	)
-)
-
-// APIRequest is to get response for api request with http-headers
-func APIRequest(url string, method string, headers map[string]string) (result []byte, err error) {
-	customTransport := &(*http.DefaultTransport.(*http.Transport)) // make shallow copy
-	customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-
-	req, err := http.NewRequest(method, url, nil)
-	if err != nil {
-		return
+// parseTimestamp parses a JupyterHub ISO8601 timestamp, returning the zero
+// Time for empty or malformed input since created/last_activity/started are
+// all optional depending on user and server state.
+func parseTimestamp(value string) time.Time {
+	if value == "" {
+		return time.Time{}
 	}
-
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
-
-	client := &http.Client{Transport: customTransport}
-	res, err := client.Do(req)
+	t, err := time.Parse(dateLayout, value)
 	if err != nil {
-		return
+		return time.Time{}
 	}
-	defer res.Body.Close()
-
-	result, err = ioutil.ReadAll(res.Body)
-	return
-}
-
-func (cc myCollector) Describe(ch chan<- *prometheus.Desc) {
-	prometheus.DescribeByCollect(cc, ch)
+	return t
 }
 
-func StopSingleServer(username string) {
-	headers := map[string]string{
-		"Authorization": "token " + *apiToken,
-	}
-	url := *apiHost + "/users/" + username + "/server"
-	_, apiErr := APIRequest(url, "DELETE", headers)
-
-	if apiErr != nil {
-		log.Println(apiErr)
-		return
-	}
-	log.Println("stopped " + username + "'s server")
-	return
-}
+func main() {
+	flag.Parse()
 
-func (cc *myCollector) GetActiveUser() (
-	activeUsers map[string]int64,
-) {
-	headers := map[string]string{
-		"Authorization": "token " + *apiToken,
+	client, err := newHubClient()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	resBody, apiErr := APIRequest(*apiHost+"/users", "GET", headers)
-
-	if apiErr != nil {
-		log.Println(apiErr)
-		return
+	webConfig, err := loadWebConfig(*webConfigFile)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	var resJSON = ResponseJSON{}
-	err := json.Unmarshal(resBody, &resJSON)
-
-	activeUsers = map[string]int64{}
-
-	if err == nil {
-		for _, user := range resJSON {
-			if user.Server != "" {
-				t, _ := time.Parse(dateLayout, user.LastActivity)
-				lastTimestamp := t.UnixNano()
-				activeUsers[user.Name] = lastTimestamp
-			}
-		}
-	} else {
-		log.Println(err)
+	policy, err := loadIdlePolicy(*idlePolicyFile, *waitHour)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	return
-}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(apiRequestsTotal, apiRequestDuration)
 
-func (cc myCollector) Collect(ch chan<- prometheus.Metric) {
-	activeUsers := cc.GetActiveUser()
-	nowTimestamp := time.Now().UnixNano()
-
-	for userName, lastActivity := range activeUsers {
-		isActive := nowTimestamp-lastActivity < *waitHour*60*60*1e9
-		if isActive {
-			ch <- prometheus.MustNewConstMetric(
-				activeUserDesc,
-				prometheus.UntypedValue,
-				float64(lastActivity),
-				userName,
-			)
-		} else {
-			StopSingleServer(userName)
-		}
-	}
-}
+	wrapped := prometheus.WrapRegistererWithPrefix(namespace+"_", reg)
+	wrapped.MustRegister(NewCollector(client, policy))
 
-func main() {
-	flag.Parse()
+	reaper := NewReaper(client, policy)
+	wrapped.MustRegister(reaper)
+	stopReaper := make(chan struct{})
+	go reaper.Run(stopReaper)
+	defer close(stopReaper)
 
-	reg := prometheus.NewPedanticRegistry()
-	cc := myCollector{}
-	prometheus.WrapRegistererWithPrefix(namespace+"_", reg).MustRegister(cc)
+	metricsHandler := maxRequestsLimiter(
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+		*webMaxRequests,
+	)
 
-	http.Handle(metricsPath, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.Handle(*webTelemetryPath, metricsHandler)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`<html>
 			<head><title>Jupyterhub Exporter</title></head>
 			<body>
 			<h1>Jupyterhub Exporter</h1>
 			<h2>v1.1</h2>
-			<p><a href="` + metricsPath + `">Metrics</a></p>
+			<p><a href="` + *webTelemetryPath + `">Metrics</a></p>
 			</body>
 			</html>`))
 	})
-	log.Println("start server")
-	log.Fatal(http.ListenAndServe(":9225", nil))
+
+	log.Println("start server on " + *webListenAddress)
+	log.Fatal(listenAndServe(*webListenAddress, basicAuth(mux, webConfig.BasicAuthUsers), webConfig))
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var idlePolicyFile = flag.String("idle-policy-file", "", "path to a YAML file configuring per-user/group idle thresholds, an allowlist, a business-hours blackout window and a minimum server-age grace period")
+
+// businessHoursConfig describes a recurring window during which the
+// reaper should not stop anything, e.g. to avoid killing servers while a
+// course is in session.
+type businessHoursConfig struct {
+	Enabled  bool     `yaml:"enabled"`
+	Timezone string   `yaml:"timezone"`
+	Start    string   `yaml:"start"` // "HH:MM", in Timezone
+	End      string   `yaml:"end"`   // "HH:MM", in Timezone
+	Days     []string `yaml:"days"`  // e.g. ["Mon","Tue","Wed","Thu","Fri"]; empty means every day
+}
+
+type idlePolicyConfig struct {
+	DefaultIdleHours    float64             `yaml:"default_idle_hours"`
+	Users               map[string]float64  `yaml:"users"`
+	Groups              map[string]float64  `yaml:"groups"`
+	Allowlist           []string            `yaml:"allowlist"`
+	BusinessHours       businessHoursConfig `yaml:"business_hours"`
+	MinServerAgeMinutes float64             `yaml:"min_server_age_minutes"`
+}
+
+// idlePolicy is the resolved, queryable form of --idle-policy-file: the
+// per-user/group thresholds the reaper applies, who it must never touch,
+// and the windows (business hours, grace period) during which it must
+// hold off even on a server that looks idle.
+type idlePolicy struct {
+	defaultThreshold time.Duration
+	userThresholds   map[string]time.Duration
+	groupThresholds  map[string]time.Duration
+	allowlist        map[string]bool
+	businessHours    businessHoursConfig
+	minServerAge     time.Duration
+	location         *time.Location
+}
+
+// loadIdlePolicy builds an idlePolicy from path, falling back to
+// defaultHours (the global --hours flag) for every user when path is
+// empty so the reaper behaves exactly as before this flag existed.
+func loadIdlePolicy(path string, defaultHours int64) (*idlePolicy, error) {
+	p := &idlePolicy{
+		defaultThreshold: time.Duration(defaultHours) * time.Hour,
+		userThresholds:   map[string]time.Duration{},
+		groupThresholds:  map[string]time.Duration{},
+		allowlist:        map[string]bool{},
+		location:         time.UTC,
+	}
+
+	if path == "" {
+		return p, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg idlePolicyConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.DefaultIdleHours > 0 {
+		p.defaultThreshold = time.Duration(cfg.DefaultIdleHours * float64(time.Hour))
+	}
+	for user, hours := range cfg.Users {
+		p.userThresholds[user] = time.Duration(hours * float64(time.Hour))
+	}
+	for group, hours := range cfg.Groups {
+		p.groupThresholds[group] = time.Duration(hours * float64(time.Hour))
+	}
+	for _, user := range cfg.Allowlist {
+		p.allowlist[user] = true
+	}
+	p.businessHours = cfg.BusinessHours
+	p.minServerAge = time.Duration(cfg.MinServerAgeMinutes * float64(time.Minute))
+
+	if cfg.BusinessHours.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.BusinessHours.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		p.location = loc
+	}
+
+	return p, nil
+}
+
+// Threshold returns the idle threshold that applies to user: a per-user
+// override wins outright, otherwise the most lenient (longest) of the
+// user's group thresholds applies, falling back to the policy default.
+func (p *idlePolicy) Threshold(user UserJSON) time.Duration {
+	if d, ok := p.userThresholds[user.Name]; ok {
+		return d
+	}
+
+	best, found := p.defaultThreshold, false
+	for _, group := range user.Groups {
+		if d, ok := p.groupThresholds[group]; ok && (!found || d > best) {
+			best, found = d, true
+		}
+	}
+	return best
+}
+
+// Allowed reports whether the reaper may ever stop this user's servers -
+// false for anyone on the allowlist (service accounts, shared demo
+// users, etc).
+func (p *idlePolicy) Allowed(username string) bool {
+	return !p.allowlist[username]
+}
+
+// WithinGracePeriod reports whether a server started at startedAt is too
+// young to reap yet, even if its last_activity hasn't updated since.
+func (p *idlePolicy) WithinGracePeriod(startedAt time.Time, now time.Time) bool {
+	return !startedAt.IsZero() && now.Sub(startedAt) < p.minServerAge
+}
+
+// WithinBusinessHours reports whether now falls inside the configured
+// reap blackout window.
+func (p *idlePolicy) WithinBusinessHours(now time.Time) bool {
+	bh := p.businessHours
+	if !bh.Enabled {
+		return false
+	}
+
+	local := now.In(p.location)
+	if len(bh.Days) > 0 && !containsDay(bh.Days, local.Weekday()) {
+		return false
+	}
+
+	start, errStart := time.ParseInLocation("15:04", bh.Start, p.location)
+	end, errEnd := time.ParseInLocation("15:04", bh.End, p.location)
+	if errStart != nil || errEnd != nil {
+		return false
+	}
+
+	minutes := local.Hour()*60 + local.Minute()
+	startMinutes := start.Hour()*60 + start.Minute()
+	endMinutes := end.Hour()*60 + end.Minute()
+
+	if startMinutes <= endMinutes {
+		return minutes >= startMinutes && minutes < endMinutes
+	}
+	// The window wraps past midnight (e.g. 22:00-06:00).
+	return minutes >= startMinutes || minutes < endMinutes
+}
+
+func containsDay(days []string, day time.Weekday) bool {
+	for _, d := range days {
+		if strings.EqualFold(d, day.String()[:3]) {
+			return true
+		}
+	}
+	return false
+}
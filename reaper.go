@@ -0,0 +1,231 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	reapInterval        = flag.Duration("reap-interval", time.Hour, "how often the reaper polls the hub and stops idle servers")
+	reapEnabled         = flag.Bool("reap-enabled", true, "enable the reaper to stop idle servers")
+	dryRun              = flag.Bool("dry-run", false, "log reaper actions without actually stopping servers")
+	checkServerProgress = flag.Bool("check-server-progress", false, "cross-check a server's own /server/progress endpoint before stopping it, in case it is active in a way last_activity does not reflect")
+)
+
+var (
+	reaperLastRunDesc = prometheus.NewDesc(
+		"reaper_last_run_timestamp_seconds",
+		"Unix timestamp of the last completed reaper run.",
+		nil, nil,
+	)
+	reaperActionsTotalDesc = prometheus.NewDesc(
+		"reaper_actions_total",
+		"Total number of reaper actions taken, by action and result.",
+		[]string{"action", "result"}, nil,
+	)
+	serversStoppedTotalDesc = prometheus.NewDesc(
+		"servers_stopped_total",
+		"Total number of servers stopped by the exporter, by reason.",
+		[]string{"reason"}, nil,
+	)
+)
+
+type reaperActionKey struct {
+	action string
+	result string
+}
+
+// Reaper independently polls the hub on a ticker and stops idle servers.
+// It is kept separate from Collector so that scraping (Collect) stays a
+// pure, side-effect-free read - the reaper's own runs are what mutate hub
+// state, and they happen on their own schedule regardless of who scrapes.
+type Reaper struct {
+	client *hubClient
+	policy *idlePolicy
+
+	mu                  sync.Mutex
+	lastRun             time.Time
+	actionsTotal        map[reaperActionKey]float64
+	serversStoppedTotal map[string]float64
+}
+
+func NewReaper(client *hubClient, policy *idlePolicy) *Reaper {
+	return &Reaper{
+		client:              client,
+		policy:              policy,
+		actionsTotal:        map[reaperActionKey]float64{},
+		serversStoppedTotal: map[string]float64{},
+	}
+}
+
+// Run blocks, triggering a reap on every tick of reapInterval until stop
+// is closed. It is a no-op if reapEnabled is false.
+func (r *Reaper) Run(stop <-chan struct{}) {
+	if !*reapEnabled {
+		return
+	}
+
+	ticker := time.NewTicker(*reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.reapOnce()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *Reaper) reapOnce() {
+	now := time.Now()
+
+	if r.policy.WithinBusinessHours(now) {
+		log.Println("reaper: within the configured business-hours window, skipping this run")
+		r.recordAction("run", "business-hours")
+		return
+	}
+
+	users, err := r.client.GetUsers()
+	if err != nil {
+		log.Println(err)
+		r.recordAction("poll", "error")
+		return
+	}
+
+	var stops []func()
+
+	for _, user := range users {
+		if !r.policy.Allowed(user.Name) {
+			continue
+		}
+
+		threshold := r.policy.Threshold(user)
+		isActive := now.Sub(parseTimestamp(user.LastActivity)) < threshold
+
+		for serverName, server := range user.Servers {
+			if server.Pending != "" {
+				// Still spawning or stopping - last_activity isn't
+				// trustworthy yet, and stopping it mid-transition would
+				// race the hub's own state machine.
+				continue
+			}
+
+			started := parseTimestamp(server.Started)
+			if r.policy.WithinGracePeriod(started, now) {
+				continue
+			}
+
+			serverIsActive := isActive
+			if serverName != "" {
+				if serverActivity := parseTimestamp(server.LastActivity); !serverActivity.IsZero() {
+					serverIsActive = now.Sub(serverActivity) < threshold
+				}
+			}
+
+			if !serverIsActive && *checkServerProgress && r.client.ServerProgressActive(user.Name, serverName) {
+				serverIsActive = true
+			}
+
+			if serverIsActive {
+				continue
+			}
+
+			userName, name := user.Name, serverName
+			stops = append(stops, func() { r.stop(userName, name, "idle") })
+		}
+	}
+
+	stopConcurrently(stops)
+
+	r.mu.Lock()
+	r.lastRun = now
+	r.mu.Unlock()
+}
+
+// stop stops a single server for the given reason ("idle" today; "api"
+// is reserved for a future manually-triggered stop path), recording both
+// the generic reaper_actions_total and the reason-labeled
+// servers_stopped_total.
+func (r *Reaper) stop(username, serverName, reason string) {
+	if *dryRun {
+		log.Println("dry-run: would stop " + username + "'s server " + serverName)
+		r.recordAction("stop", "dry-run")
+		return
+	}
+
+	if err := r.client.StopSingleServer(username, serverName); err != nil {
+		log.Println(err)
+		r.recordAction("stop", "error")
+		return
+	}
+	r.recordAction("stop", "success")
+	r.recordStop(reason)
+}
+
+func (r *Reaper) recordAction(action, result string) {
+	r.mu.Lock()
+	r.actionsTotal[reaperActionKey{action, result}]++
+	r.mu.Unlock()
+}
+
+func (r *Reaper) recordStop(reason string) {
+	r.mu.Lock()
+	r.serversStoppedTotal[reason]++
+	r.mu.Unlock()
+}
+
+// stopConcurrently runs the given stop closures bounded to maxConcurrentStop
+// at a time, so the reaper doesn't hammer the hub API when many servers are
+// idle at once. A non-positive maxConcurrentStop (e.g. --max-concurrent-stops=0,
+// following the same "0 disables the limit" convention as --web.max-requests)
+// runs every stop without bounding concurrency, rather than deadlocking on an
+// unusable zero-sized semaphore.
+func stopConcurrently(stops []func()) {
+	limit := *maxConcurrentStop
+	if limit <= 0 {
+		limit = len(stops)
+	}
+	if limit == 0 {
+		return
+	}
+
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+
+	for _, stop := range stops {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(stop func()) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			stop()
+		}(stop)
+	}
+
+	wg.Wait()
+}
+
+func (r *Reaper) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(r, ch)
+}
+
+func (r *Reaper) Collect(ch chan<- prometheus.Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.lastRun.IsZero() {
+		ch <- prometheus.MustNewConstMetric(reaperLastRunDesc, prometheus.GaugeValue, float64(r.lastRun.Unix()))
+	}
+	for key, count := range r.actionsTotal {
+		ch <- prometheus.MustNewConstMetric(reaperActionsTotalDesc, prometheus.CounterValue, count, key.action, key.result)
+	}
+	for reason, count := range r.serversStoppedTotal {
+		ch <- prometheus.MustNewConstMetric(serversStoppedTotalDesc, prometheus.CounterValue, count, reason)
+	}
+}
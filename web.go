@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	webListenAddress = flag.String("web.listen-address", ":9225", "address on which to expose metrics and web interface")
+	webTelemetryPath = flag.String("web.telemetry-path", metricsPath, "path under which to expose metrics")
+	webConfigFile    = flag.String("web.config.file", "", "path to a YAML file enabling TLS and/or basic auth on the web interface")
+	webMaxRequests   = flag.Int("web.max-requests", 40, "maximum number of parallel scrape requests, 0 to disable the limit")
+)
+
+// webConfig is the shape of --web.config.file: a minimal, local stand-in
+// for github.com/prometheus/exporter-toolkit/web's config, covering just
+// the TLS and basic-auth cases this exporter needs.
+type webConfig struct {
+	TLSServerConfig *webTLSConfig     `yaml:"tls_server_config"`
+	BasicAuthUsers  map[string]string `yaml:"basic_auth_users"`
+}
+
+type webTLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+func loadWebConfig(path string) (*webConfig, error) {
+	if path == "" {
+		return &webConfig{}, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg webConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// basicAuth wraps next with HTTP basic auth, checking the supplied
+// password against a bcrypt hash per username - the same scheme
+// node_exporter's web.config.file uses.
+func basicAuth(next http.Handler, users map[string]string) http.Handler {
+	if len(users) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		hash, known := users[user]
+		if !ok || !known || bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="jupyterhub_exporter"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxRequestsLimiter caps the number of in-flight requests next may be
+// serving at once, so repeated scrapes can't pile up and hammer the hub
+// API via the collector underneath.
+func maxRequestsLimiter(next http.Handler, max int) http.Handler {
+	if max <= 0 {
+		return next
+	}
+
+	sem := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			next.ServeHTTP(w, r)
+		default:
+			http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// listenAndServe serves handler on addr, terminating TLS itself when cfg
+// carries a tls_server_config.
+func listenAndServe(addr string, handler http.Handler, cfg *webConfig) error {
+	srv := &http.Server{Addr: addr, Handler: handler}
+
+	if cfg.TLSServerConfig == nil {
+		return srv.ListenAndServe()
+	}
+
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	return srv.ListenAndServeTLS(cfg.TLSServerConfig.CertFile, cfg.TLSServerConfig.KeyFile)
+}